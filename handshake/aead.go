@@ -0,0 +1,56 @@
+package handshake
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// chacha20poly1305TagSize is the authentication tag overhead added by Seal.
+const chacha20poly1305TagSize = 16
+
+// nonce builds the 96-bit ChaCha20-Poly1305 nonce for counter, following
+// the Noise convention of a zero prefix and a little-endian 64-bit counter.
+func nonce(counter uint64) [chacha20poly1305.NonceSize]byte {
+	var n [chacha20poly1305.NonceSize]byte
+	binary.LittleEndian.PutUint64(n[4:], counter)
+	return n
+}
+
+// aeadSeal and aeadOpen construct a fresh cipher.AEAD from key on every
+// call. They back symmetricState's encryptAndHash/decryptAndHash, each of
+// which runs at most twice per handshake message, so the construction cost
+// doesn't matter there. CipherState's Encrypt/Decrypt, which run once per
+// transport message for the life of a connection, instead build their
+// cipher.AEAD once (see NewCipherState) and call sealWithAEAD/openWithAEAD.
+func aeadSeal(key [32]byte, counter uint64, ad, plaintext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return sealWithAEAD(aead, counter, ad, plaintext), nil
+}
+
+func aeadOpen(key [32]byte, counter uint64, ad, ciphertext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return openWithAEAD(aead, counter, ad, ciphertext)
+}
+
+func sealWithAEAD(aead cipher.AEAD, counter uint64, ad, plaintext []byte) []byte {
+	n := nonce(counter)
+	return aead.Seal(nil, n[:], plaintext, ad)
+}
+
+func openWithAEAD(aead cipher.AEAD, counter uint64, ad, ciphertext []byte) ([]byte, error) {
+	n := nonce(counter)
+	pt, err := aead.Open(nil, n[:], ciphertext, ad)
+	if err != nil {
+		return nil, errors.New("handshake: decryption failed")
+	}
+	return pt, nil
+}
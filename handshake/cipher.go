@@ -0,0 +1,58 @@
+package handshake
+
+import (
+	"crypto/cipher"
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// ErrNonceExhausted is returned once a CipherState's 64-bit nonce counter
+// would wrap around. The channel must be discarded and a fresh handshake
+// performed; nonces are never reused under the same key.
+var ErrNonceExhausted = errors.New("handshake: nonce counter exhausted")
+
+// CipherState is a single-direction AEAD channel keyed from one half of a
+// completed handshake's Split, with a strictly increasing 64-bit nonce
+// counter as used by Noise transport messages.
+type CipherState struct {
+	aead    cipher.AEAD // built once in NewCipherState and reused by every Encrypt/Decrypt
+	counter uint64
+}
+
+// NewCipherState returns a CipherState seeded with the given transport key.
+// The cipher.AEAD is constructed once here rather than per call, since a
+// CipherState backs every message on a connection for its lifetime.
+func NewCipherState(key [32]byte) *CipherState {
+	aead, err := chacha20poly1305.New(key[:])
+	if err != nil {
+		// chacha20poly1305.New only errors on a wrong-size key, and key is
+		// always 32 bytes.
+		panic(err)
+	}
+	return &CipherState{aead: aead}
+}
+
+// Encrypt seals plaintext under the next nonce and advances the counter.
+func (c *CipherState) Encrypt(plaintext []byte) ([]byte, error) {
+	if c.counter == ^uint64(0) {
+		return nil, ErrNonceExhausted
+	}
+	ct := sealWithAEAD(c.aead, c.counter, nil, plaintext)
+	c.counter++
+	return ct, nil
+}
+
+// Decrypt opens ciphertext sealed under the next expected nonce and
+// advances the counter.
+func (c *CipherState) Decrypt(ciphertext []byte) ([]byte, error) {
+	if c.counter == ^uint64(0) {
+		return nil, ErrNonceExhausted
+	}
+	pt, err := openWithAEAD(c.aead, c.counter, nil, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	c.counter++
+	return pt, nil
+}
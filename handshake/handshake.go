@@ -0,0 +1,338 @@
+// Package handshake implements the Noise IK handshake pattern over
+// Curve25519, ChaCha20-Poly1305 and BLAKE2s (Noise_IK_25519_ChaChaPoly_BLAKE2s),
+// the same construction used by Tailscale's control-plane transport. The
+// initiator must already know the responder's long-term static public key;
+// the two-message exchange authenticates both sides and derives a pair of
+// directional AEAD keys without leaking either static key to a passive
+// observer.
+package handshake
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"errors"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/curve25519"
+)
+
+// protocolName is the Noise protocol name used to initialize the
+// handshake hash, as specified by the Noise Protocol Framework.
+const protocolName = "Noise_IK_25519_ChaChaPoly_BLAKE2s"
+
+// KeyPair is a Curve25519 keypair, used for both static and ephemeral keys.
+type KeyPair struct {
+	Public  [32]byte
+	Private [32]byte
+}
+
+// randReader supplies randomness for GenerateKeyPair. It is a variable
+// instead of a direct crypto/rand.Reader reference so tests can substitute
+// a deterministic source to reproduce a fixed handshake transcript; it must
+// never be changed outside of tests.
+var randReader io.Reader = rand.Reader
+
+// GenerateKeyPair returns a new random Curve25519 keypair.
+func GenerateKeyPair() (KeyPair, error) {
+	var kp KeyPair
+	if _, err := io.ReadFull(randReader, kp.Private[:]); err != nil {
+		return kp, err
+	}
+	curve25519.ScalarBaseMult(&kp.Public, &kp.Private)
+	return kp, nil
+}
+
+// dh performs a Curve25519 scalar multiplication.
+func dh(priv, pub *[32]byte) [32]byte {
+	var shared [32]byte
+	curve25519.ScalarMult(&shared, priv, pub)
+	return shared
+}
+
+func newBlake2s() hash.Hash {
+	h, err := blake2s.New256(nil)
+	if err != nil {
+		// blake2s.New256 only errors on an oversized key, and we never
+		// pass one.
+		panic(err)
+	}
+	return h
+}
+
+// symmetricState tracks the running chaining key and handshake hash, as
+// defined by the Noise Protocol Framework's SymmetricState object.
+type symmetricState struct {
+	ck  [32]byte // chaining key
+	h   [32]byte // handshake hash, mixed into every message
+	key *[32]byte
+}
+
+func newSymmetricState() *symmetricState {
+	ss := &symmetricState{h: blake2s.Sum256([]byte(protocolName))}
+	ss.ck = ss.h
+	return ss
+}
+
+func (ss *symmetricState) mixHash(data []byte) {
+	h := newBlake2s()
+	h.Write(ss.h[:])
+	h.Write(data)
+	copy(ss.h[:], h.Sum(nil))
+}
+
+// mixKey is Noise's MixKey(input): derive two outputs from the current
+// chaining key and a DH result via HKDF, keep the first as the new
+// chaining key and the second as the symmetric encryption key.
+func (ss *symmetricState) mixKey(input []byte) {
+	ck2, k2 := hkdf2(ss.ck[:], input)
+	ss.ck = ck2
+	ss.key = &k2
+}
+
+// hkdf2 is the two-output HKDF construction used throughout Noise:
+// HMAC-BLAKE2s acts as the PRF, chainKey as the salt.
+func hkdf2(chainKey, input []byte) (out1, out2 [32]byte) {
+	tempKey := hmacHash(chainKey, input)
+	out1 = hmacHash(tempKey[:], []byte{0x01})
+	out2 = hmacHash(tempKey[:], append(append([]byte{}, out1[:]...), 0x02))
+	return out1, out2
+}
+
+func hmacHash(key, data []byte) [32]byte {
+	mac := hmac.New(newBlake2s, key)
+	mac.Write(data)
+	var sum [32]byte
+	copy(sum[:], mac.Sum(nil))
+	return sum
+}
+
+// encryptAndHash encrypts plaintext with the current key (if any) using the
+// handshake hash as associated data, then mixes the ciphertext into the
+// hash, per Noise's EncryptAndHash.
+func (ss *symmetricState) encryptAndHash(plaintext []byte) ([]byte, error) {
+	if ss.key == nil {
+		ss.mixHash(plaintext)
+		return plaintext, nil
+	}
+	ct, err := aeadSeal(*ss.key, 0, ss.h[:], plaintext)
+	if err != nil {
+		return nil, err
+	}
+	ss.mixHash(ct)
+	return ct, nil
+}
+
+// decryptAndHash is the receive-side counterpart of encryptAndHash.
+func (ss *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	if ss.key == nil {
+		ss.mixHash(ciphertext)
+		return ciphertext, nil
+	}
+	pt, err := aeadOpen(*ss.key, 0, ss.h[:], ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	ss.mixHash(ciphertext)
+	return pt, nil
+}
+
+// split derives the two directional transport keys once the handshake is
+// complete: the initiator's send key is the responder's receive key, and
+// vice versa.
+func (ss *symmetricState) split() (send, recv [32]byte) {
+	return hkdf2(ss.ck[:], nil)
+}
+
+// HandshakeState drives one side of a single Noise IK handshake. It is not
+// safe for concurrent use and must be discarded once Split has been called.
+type HandshakeState struct {
+	ss        *symmetricState
+	initiator bool
+	s         KeyPair  // local static keypair
+	e         KeyPair  // local ephemeral keypair
+	rs        [32]byte // remote static public key
+	re        [32]byte // remote ephemeral public key
+	rsKnown   bool
+}
+
+// NewInitiator starts an IK handshake as the initiator, who must already
+// know the responder's static public key.
+func NewInitiator(static KeyPair, responderStatic [32]byte) (*HandshakeState, error) {
+	hs := &HandshakeState{ss: newSymmetricState(), initiator: true, s: static, rs: responderStatic, rsKnown: true}
+	hs.ss.mixHash(nil) // empty prologue
+	hs.ss.mixHash(responderStatic[:])
+	return hs, nil
+}
+
+// NewResponder starts an IK handshake as the responder.
+func NewResponder(static KeyPair) (*HandshakeState, error) {
+	hs := &HandshakeState{ss: newSymmetricState(), initiator: false, s: static}
+	hs.ss.mixHash(nil) // empty prologue
+	hs.ss.mixHash(static.Public[:])
+	return hs, nil
+}
+
+// WriteMessage1 is called by the initiator to produce "-> e, es, s, ss"
+// (with an optional payload), the first and only message it sends during
+// the handshake.
+func (hs *HandshakeState) WriteMessage1(payload []byte) ([]byte, error) {
+	if !hs.initiator {
+		return nil, errors.New("handshake: WriteMessage1 called by responder")
+	}
+	e, err := GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	hs.e = e
+	hs.ss.mixHash(e.Public[:])
+
+	es := dh(&e.Private, &hs.rs)
+	hs.ss.mixKey(es[:])
+
+	encS, err := hs.ss.encryptAndHash(hs.s.Public[:])
+	if err != nil {
+		return nil, err
+	}
+
+	ss := dh(&hs.s.Private, &hs.rs)
+	hs.ss.mixKey(ss[:])
+
+	encPayload, err := hs.ss.encryptAndHash(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := append([]byte{}, e.Public[:]...)
+	msg = append(msg, encS...)
+	msg = append(msg, encPayload...)
+	return msg, nil
+}
+
+// ReadMessage1 is called by the responder to consume the initiator's
+// message and returns the decrypted payload.
+func (hs *HandshakeState) ReadMessage1(msg []byte) ([]byte, error) {
+	if hs.initiator {
+		return nil, errors.New("handshake: ReadMessage1 called by initiator")
+	}
+	if len(msg) < 32 {
+		return nil, errors.New("handshake: message 1 too short")
+	}
+	copy(hs.re[:], msg[:32])
+	hs.ss.mixHash(hs.re[:])
+	rest := msg[32:]
+
+	es := dh(&hs.s.Private, &hs.re)
+	hs.ss.mixKey(es[:])
+
+	encSLen := 32
+	if hs.ss.key != nil {
+		encSLen += chacha20poly1305TagSize
+	}
+	if len(rest) < encSLen {
+		return nil, errors.New("handshake: message 1 truncated static key")
+	}
+	rsBytes, err := hs.ss.decryptAndHash(rest[:encSLen])
+	if err != nil {
+		return nil, err
+	}
+	copy(hs.rs[:], rsBytes)
+	hs.rsKnown = true
+	rest = rest[encSLen:]
+
+	ss := dh(&hs.s.Private, &hs.rs)
+	hs.ss.mixKey(ss[:])
+
+	payload, err := hs.ss.decryptAndHash(rest)
+	if err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// WriteMessage2 is called by the responder to produce "<- e, ee, se", the
+// second and final handshake message.
+func (hs *HandshakeState) WriteMessage2(payload []byte) ([]byte, error) {
+	if hs.initiator {
+		return nil, errors.New("handshake: WriteMessage2 called by initiator")
+	}
+	e, err := GenerateKeyPair()
+	if err != nil {
+		return nil, err
+	}
+	hs.e = e
+	hs.ss.mixHash(e.Public[:])
+
+	ee := dh(&e.Private, &hs.re)
+	hs.ss.mixKey(ee[:])
+
+	se := dh(&e.Private, &hs.rs)
+	hs.ss.mixKey(se[:])
+
+	encPayload, err := hs.ss.encryptAndHash(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := append([]byte{}, e.Public[:]...)
+	msg = append(msg, encPayload...)
+	return msg, nil
+}
+
+// ReadMessage2 is called by the initiator to consume the responder's final
+// message, authenticating the responder's static key against the one
+// passed to NewInitiator.
+func (hs *HandshakeState) ReadMessage2(msg []byte) ([]byte, error) {
+	if !hs.initiator {
+		return nil, errors.New("handshake: ReadMessage2 called by responder")
+	}
+	if len(msg) < 32 {
+		return nil, errors.New("handshake: message 2 too short")
+	}
+	copy(hs.re[:], msg[:32])
+	hs.ss.mixHash(hs.re[:])
+	rest := msg[32:]
+
+	ee := dh(&hs.e.Private, &hs.re)
+	hs.ss.mixKey(ee[:])
+
+	se := dh(&hs.s.Private, &hs.re)
+	hs.ss.mixKey(se[:])
+
+	payload, err := hs.ss.decryptAndHash(rest)
+	if err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// complete reports whether both handshake messages have been processed, so
+// Split can derive transport keys.
+func (hs *HandshakeState) complete() bool {
+	return hs.e.Public != [32]byte{} && hs.rsKnown && hs.re != [32]byte{}
+}
+
+// Result holds everything a transport needs once the handshake finishes.
+type Result struct {
+	Send         [32]byte // AEAD key for messages this side sends
+	Recv         [32]byte // AEAD key for messages this side receives
+	Hash         [32]byte // final handshake hash, for channel binding
+	RemoteStatic [32]byte // the peer's authenticated static public key
+}
+
+// Split finalizes the handshake and returns the directional transport keys.
+// It must be called only after both handshake messages have been processed.
+func (hs *HandshakeState) Split() (Result, error) {
+	if !hs.complete() {
+		return Result{}, errors.New("handshake: not complete")
+	}
+	k1, k2 := hs.ss.split()
+	res := Result{Hash: hs.ss.h, RemoteStatic: hs.rs}
+	if hs.initiator {
+		res.Send, res.Recv = k1, k2
+	} else {
+		res.Send, res.Recv = k2, k1
+	}
+	return res, nil
+}
@@ -0,0 +1,315 @@
+package handshake
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/binary"
+	"hash"
+	"io"
+	"testing"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// staticKeypairs are fixed Curve25519 keypairs used as test vectors so the
+// handshake transcript is reproducible across runs.
+var (
+	initiatorStatic = KeyPair{
+		Private: [32]byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f, 0x20},
+	}
+	responderStatic = KeyPair{
+		Private: [32]byte{0x21, 0x22, 0x23, 0x24, 0x25, 0x26, 0x27, 0x28, 0x29, 0x2a, 0x2b, 0x2c, 0x2d, 0x2e, 0x2f, 0x30, 0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x3b, 0x3c, 0x3d, 0x3e, 0x3f, 0x40},
+	}
+)
+
+func init() {
+	// Derive each vector's public half from its hardcoded private scalar,
+	// so the fixtures above only need to carry one value per side.
+	curve25519.ScalarBaseMult(&initiatorStatic.Public, &initiatorStatic.Private)
+	curve25519.ScalarBaseMult(&responderStatic.Public, &responderStatic.Private)
+}
+
+// TestHandshakeIK runs a full IK exchange between fixed static keypairs and
+// asserts both sides converge on the same transport keys and handshake
+// hash, with each side's send key equal to the other's receive key.
+func TestHandshakeIK(t *testing.T) {
+	init, err := NewInitiator(initiatorStatic, responderStatic.Public)
+	if err != nil {
+		t.Fatalf("NewInitiator: %v", err)
+	}
+	resp, err := NewResponder(responderStatic)
+	if err != nil {
+		t.Fatalf("NewResponder: %v", err)
+	}
+
+	msg1, err := init.WriteMessage1([]byte("hello"))
+	if err != nil {
+		t.Fatalf("WriteMessage1: %v", err)
+	}
+	payload1, err := resp.ReadMessage1(msg1)
+	if err != nil {
+		t.Fatalf("ReadMessage1: %v", err)
+	}
+	if string(payload1) != "hello" {
+		t.Fatalf("payload1 = %q, want %q", payload1, "hello")
+	}
+
+	msg2, err := resp.WriteMessage2([]byte("world"))
+	if err != nil {
+		t.Fatalf("WriteMessage2: %v", err)
+	}
+	payload2, err := init.ReadMessage2(msg2)
+	if err != nil {
+		t.Fatalf("ReadMessage2: %v", err)
+	}
+	if string(payload2) != "world" {
+		t.Fatalf("payload2 = %q, want %q", payload2, "world")
+	}
+
+	initResult, err := init.Split()
+	if err != nil {
+		t.Fatalf("initiator Split: %v", err)
+	}
+	respResult, err := resp.Split()
+	if err != nil {
+		t.Fatalf("responder Split: %v", err)
+	}
+
+	if initResult.Send != respResult.Recv {
+		t.Errorf("initiator send key != responder recv key")
+	}
+	if initResult.Recv != respResult.Send {
+		t.Errorf("initiator recv key != responder send key")
+	}
+	if initResult.Hash != respResult.Hash {
+		t.Errorf("handshake hash mismatch between initiator and responder")
+	}
+	if !bytes.Equal(initResult.RemoteStatic[:], responderStatic.Public[:]) {
+		t.Errorf("initiator did not authenticate the expected responder static key")
+	}
+}
+
+// TestHandshakeIKRejectsWrongStatic ensures the initiator's subsequent use
+// of the handshake result can be checked against the expected responder
+// key: if Dial is pointed at the wrong key, NewInitiator binds to it, and
+// the resulting transcript will not match what the real responder computes
+// (ReadMessage1/2 fail closed rather than silently authenticating).
+func TestHandshakeIKRejectsWrongStatic(t *testing.T) {
+	var wrongStatic [32]byte
+	wrongStatic[0] = 0xff
+
+	init, err := NewInitiator(initiatorStatic, wrongStatic)
+	if err != nil {
+		t.Fatalf("NewInitiator: %v", err)
+	}
+	resp, err := NewResponder(responderStatic)
+	if err != nil {
+		t.Fatalf("NewResponder: %v", err)
+	}
+
+	msg1, err := init.WriteMessage1(nil)
+	if err != nil {
+		t.Fatalf("WriteMessage1: %v", err)
+	}
+	if _, err := resp.ReadMessage1(msg1); err == nil {
+		t.Fatal("ReadMessage1 succeeded against a mismatched responder static key, want error")
+	}
+}
+
+// TestCipherStateRoundTrip exercises the post-handshake transport keys.
+func TestCipherStateRoundTrip(t *testing.T) {
+	var key [32]byte
+	copy(key[:], []byte("0123456789abcdef0123456789abcdef"))
+
+	send := NewCipherState(key)
+	recv := NewCipherState(key)
+
+	for i := 0; i < 3; i++ {
+		ct, err := send.Encrypt([]byte("ping"))
+		if err != nil {
+			t.Fatalf("Encrypt: %v", err)
+		}
+		pt, err := recv.Decrypt(ct)
+		if err != nil {
+			t.Fatalf("Decrypt: %v", err)
+		}
+		if string(pt) != "ping" {
+			t.Fatalf("round %d: got %q, want %q", i, pt, "ping")
+		}
+	}
+}
+
+// TestCipherStateNonceExhaustion checks that Encrypt refuses to reuse a
+// nonce once the 64-bit counter has been driven to its maximum value.
+func TestCipherStateNonceExhaustion(t *testing.T) {
+	var key [32]byte
+	c := NewCipherState(key)
+	c.counter = ^uint64(0)
+
+	if _, err := c.Encrypt([]byte("x")); err != ErrNonceExhausted {
+		t.Fatalf("Encrypt at exhausted counter: got %v, want %v", err, ErrNonceExhausted)
+	}
+}
+
+// fixedReader is a deterministic io.Reader that hands out a fixed sequence
+// of 32-byte values, one per Read call, so a test can pin the ephemeral
+// keys an otherwise-random handshake generates.
+type fixedReader struct {
+	values [][32]byte
+}
+
+func (r *fixedReader) Read(p []byte) (int, error) {
+	if len(r.values) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if len(p) != 32 {
+		return 0, io.ErrShortBuffer
+	}
+	copy(p, r.values[0][:])
+	r.values = r.values[1:]
+	return 32, nil
+}
+
+// refMixHash and refHkdf2 below reimplement Noise's MixHash and HKDF2 from
+// the protocol spec, independently of symmetricState.mixHash/mixKey, so
+// TestHandshakeIKFixedTranscript checks the package's output against a
+// second implementation rather than against itself.
+func refMixHash(h [32]byte, data []byte) [32]byte {
+	hh, err := blake2s.New256(nil)
+	if err != nil {
+		panic(err)
+	}
+	hh.Write(h[:])
+	hh.Write(data)
+	var out [32]byte
+	copy(out[:], hh.Sum(nil))
+	return out
+}
+
+func refHmac(key, data []byte) [32]byte {
+	mac := hmac.New(func() hash.Hash { h, _ := blake2s.New256(nil); return h }, key)
+	mac.Write(data)
+	var out [32]byte
+	copy(out[:], mac.Sum(nil))
+	return out
+}
+
+func refHkdf2(chainKey, input []byte) (out1, out2 [32]byte) {
+	tempKey := refHmac(chainKey, input)
+	out1 = refHmac(tempKey[:], []byte{0x01})
+	out2 = refHmac(tempKey[:], append(append([]byte{}, out1[:]...), 0x02))
+	return out1, out2
+}
+
+func refSeal(key [32]byte, ad, plaintext []byte) []byte {
+	aead, err := chacha20poly1305.New(key[:])
+	if err != nil {
+		panic(err)
+	}
+	var n [chacha20poly1305.NonceSize]byte
+	binary.LittleEndian.PutUint64(n[4:], 0)
+	return aead.Seal(nil, n[:], plaintext, ad)
+}
+
+// TestHandshakeIKFixedTranscript pins the ephemeral keys of a handshake
+// between the fixed static keypairs above, then recomputes the expected
+// handshake hash and both message ciphertexts from the Noise spec using a
+// second, independent implementation of MixHash/HKDF2/AEAD (refMixHash,
+// refHkdf2, refSeal above), rather than calling symmetricState's own
+// methods. Diverging from the package's output here would mean this
+// implementation is non-interoperable with any conformant
+// Noise_IK_25519_ChaChaPoly_BLAKE2s peer, even though the package's own
+// initiator/responder still agree with each other.
+func TestHandshakeIKFixedTranscript(t *testing.T) {
+	var initEphemeral, respEphemeral [32]byte
+	initEphemeral[0] = 0x50
+	respEphemeral[0] = 0x60
+
+	orig := randReader
+	defer func() { randReader = orig }()
+	randReader = &fixedReader{values: [][32]byte{initEphemeral, respEphemeral}}
+
+	init, err := NewInitiator(initiatorStatic, responderStatic.Public)
+	if err != nil {
+		t.Fatalf("NewInitiator: %v", err)
+	}
+	resp, err := NewResponder(responderStatic)
+	if err != nil {
+		t.Fatalf("NewResponder: %v", err)
+	}
+
+	msg1, err := init.WriteMessage1(nil)
+	if err != nil {
+		t.Fatalf("WriteMessage1: %v", err)
+	}
+	if _, err := resp.ReadMessage1(msg1); err != nil {
+		t.Fatalf("ReadMessage1: %v", err)
+	}
+	msg2, err := resp.WriteMessage2(nil)
+	if err != nil {
+		t.Fatalf("WriteMessage2: %v", err)
+	}
+	if _, err := init.ReadMessage2(msg2); err != nil {
+		t.Fatalf("ReadMessage2: %v", err)
+	}
+
+	initResult, err := init.Split()
+	if err != nil {
+		t.Fatalf("initiator Split: %v", err)
+	}
+
+	// Independently recompute the transcript.
+	var eInit, eResp KeyPair
+	eInit.Private = initEphemeral
+	curve25519.ScalarBaseMult(&eInit.Public, &eInit.Private)
+	eResp.Private = respEphemeral
+	curve25519.ScalarBaseMult(&eResp.Public, &eResp.Private)
+
+	h := blake2s.Sum256([]byte(protocolName))
+	ck := h
+	h = refMixHash(h, nil)                       // empty prologue
+	h = refMixHash(h, responderStatic.Public[:]) // pre-message: initiator/responder both know responder's static
+
+	h = refMixHash(h, eInit.Public[:])
+	var es, ssDH, ee, se [32]byte
+	curve25519.ScalarMult(&es, &eInit.Private, &responderStatic.Public)
+	ck, k1 := refHkdf2(ck[:], es[:])
+	c1 := refSeal(k1, h[:], initiatorStatic.Public[:])
+	h = refMixHash(h, c1)
+	curve25519.ScalarMult(&ssDH, &initiatorStatic.Private, &responderStatic.Public)
+	ck, k2 := refHkdf2(ck[:], ssDH[:])
+	c2 := refSeal(k2, h[:], nil)
+	h = refMixHash(h, c2)
+
+	wantMsg1 := append(append([]byte{}, eInit.Public[:]...), append(c1, c2...)...)
+	if !bytes.Equal(msg1, wantMsg1) {
+		t.Fatalf("msg1 = %x, want %x", msg1, wantMsg1)
+	}
+
+	h = refMixHash(h, eResp.Public[:])
+	curve25519.ScalarMult(&ee, &eResp.Private, &eInit.Public)
+	ck, k3 := refHkdf2(ck[:], ee[:])
+	curve25519.ScalarMult(&se, &eResp.Private, &initiatorStatic.Public)
+	ck, k4 := refHkdf2(ck[:], se[:])
+	c3 := refSeal(k4, h[:], nil)
+	h = refMixHash(h, c3)
+	_ = k3
+
+	wantMsg2 := append(append([]byte{}, eResp.Public[:]...), c3...)
+	if !bytes.Equal(msg2, wantMsg2) {
+		t.Fatalf("msg2 = %x, want %x", msg2, wantMsg2)
+	}
+	if initResult.Hash != h {
+		t.Fatalf("handshake hash = %x, want %x", initResult.Hash, h)
+	}
+
+	wantSend, wantRecv := refHkdf2(ck[:], nil)
+	if initResult.Send != wantSend {
+		t.Fatalf("initiator send key = %x, want %x", initResult.Send, wantSend)
+	}
+	if initResult.Recv != wantRecv {
+		t.Fatalf("initiator recv key = %x, want %x", initResult.Recv, wantRecv)
+	}
+}
@@ -0,0 +1,47 @@
+package keystore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// AuthorizedKeys is a set of client static public keys a server will accept
+// connections from, backed by a text file with one base64-encoded public
+// key per line (blank lines and lines starting with '#' are ignored).
+type AuthorizedKeys struct {
+	keys map[[32]byte]bool
+}
+
+// LoadAuthorizedKeys reads the authorized-keys file at path.
+func LoadAuthorizedKeys(path string) (*AuthorizedKeys, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ak := &AuthorizedKeys{keys: make(map[[32]byte]bool)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := decodeKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("keystore: authorized-keys entry %q: %w", line, err)
+		}
+		ak.keys[key] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ak, nil
+}
+
+// Allowed reports whether key is present in the authorized-keys file.
+func (ak *AuthorizedKeys) Allowed(key [32]byte) bool {
+	return ak.keys[key]
+}
@@ -0,0 +1,64 @@
+// Package keystore persists long-term Curve25519 identities and the
+// known-hosts / authorized-keys trust files used to authenticate peers by
+// those identities, the way an ssh client or server would.
+package keystore
+
+import (
+	"os"
+
+	"golang.org/x/crypto/curve25519"
+
+	"github.com/pyk/go-challenge-2/handshake"
+)
+
+// KeyStore loads and saves a single Curve25519 identity keypair from a
+// file containing the raw 32-byte private key.
+type KeyStore struct {
+	Path string
+}
+
+// NewKeyStore returns a KeyStore backed by the file at path.
+func NewKeyStore(path string) KeyStore {
+	return KeyStore{path}
+}
+
+// Load reads the identity keypair from the store's file.
+func (k KeyStore) Load() (handshake.KeyPair, error) {
+	var kp handshake.KeyPair
+	data, err := os.ReadFile(k.Path)
+	if err != nil {
+		return kp, err
+	}
+	if len(data) != 32 {
+		return kp, &os.PathError{Op: "load", Path: k.Path, Err: os.ErrInvalid}
+	}
+	copy(kp.Private[:], data)
+	curve25519.ScalarBaseMult(&kp.Public, &kp.Private)
+	return kp, nil
+}
+
+// Save writes kp's private key to the store's file, creating it with
+// owner-only permissions if it doesn't already exist.
+func (k KeyStore) Save(kp handshake.KeyPair) error {
+	return os.WriteFile(k.Path, kp.Private[:], 0600)
+}
+
+// LoadOrGenerate loads the identity at the store's path, generating and
+// persisting a new one if the file does not exist yet.
+func (k KeyStore) LoadOrGenerate() (handshake.KeyPair, error) {
+	kp, err := k.Load()
+	if err == nil {
+		return kp, nil
+	}
+	if !os.IsNotExist(err) {
+		return handshake.KeyPair{}, err
+	}
+	kp, err = handshake.GenerateKeyPair()
+	if err != nil {
+		return handshake.KeyPair{}, err
+	}
+	if err := k.Save(kp); err != nil {
+		return handshake.KeyPair{}, err
+	}
+	return kp, nil
+}
@@ -0,0 +1,130 @@
+package keystore
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownHost is returned when a known-hosts file has no entry for the
+// address being verified.
+var ErrUnknownHost = errors.New("keystore: unknown host")
+
+// ErrHostKeyMismatch is returned when an address's recorded public key
+// differs from the one being verified, a sign of a substituted server or a
+// man-in-the-middle.
+var ErrHostKeyMismatch = errors.New("keystore: host key does not match known-hosts entry")
+
+// KnownHosts maps "host:port" to the server static public key expected
+// there, backed by a text file with one "addr base64(pubkey)" entry per
+// line (blank lines and lines starting with '#' are ignored).
+type KnownHosts struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string][32]byte
+}
+
+// LoadKnownHosts reads the known-hosts file at path. A missing file is not
+// an error: it behaves like an empty KnownHosts so trust-on-first-use can
+// populate it from scratch.
+func LoadKnownHosts(path string) (*KnownHosts, error) {
+	kh := &KnownHosts{path: path, entries: make(map[string][32]byte)}
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return kh, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("keystore: malformed known-hosts line %q", line)
+		}
+		key, err := decodeKey(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("keystore: known-hosts entry for %s: %w", fields[0], err)
+		}
+		kh.entries[fields[0]] = key
+	}
+	return kh, scanner.Err()
+}
+
+// Lookup returns the public key known-hosts has recorded for addr.
+func (kh *KnownHosts) Lookup(addr string) ([32]byte, error) {
+	kh.mu.Lock()
+	defer kh.mu.Unlock()
+	key, ok := kh.entries[addr]
+	if !ok {
+		return [32]byte{}, ErrUnknownHost
+	}
+	return key, nil
+}
+
+// Verify checks key against addr's known-hosts entry. If addr has no entry
+// yet, Verify fails with ErrUnknownHost unless trustOnFirstUse is set, in
+// which case key is recorded as trusted and appended to the known-hosts
+// file. If addr has an entry that does not match key, Verify always fails
+// with ErrHostKeyMismatch, regardless of trustOnFirstUse.
+func (kh *KnownHosts) Verify(addr string, key [32]byte, trustOnFirstUse bool) error {
+	want, err := kh.Lookup(addr)
+	if err == ErrUnknownHost {
+		if !trustOnFirstUse {
+			return ErrUnknownHost
+		}
+		return kh.add(addr, key)
+	}
+	if err != nil {
+		return err
+	}
+	if want != key {
+		return ErrHostKeyMismatch
+	}
+	return nil
+}
+
+func (kh *KnownHosts) add(addr string, key [32]byte) error {
+	kh.mu.Lock()
+	defer kh.mu.Unlock()
+
+	f, err := os.OpenFile(kh.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintf(f, "%s %s\n", addr, encodeKey(key)); err != nil {
+		return err
+	}
+	kh.entries[addr] = key
+	return nil
+}
+
+func encodeKey(key [32]byte) string {
+	return base64.StdEncoding.EncodeToString(key[:])
+}
+
+func decodeKey(s string) ([32]byte, error) {
+	var key [32]byte
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return key, err
+	}
+	if len(raw) != 32 {
+		return key, fmt.Errorf("invalid public key length %d", len(raw))
+	}
+	copy(key[:], raw)
+	return key, nil
+}
@@ -1,8 +1,9 @@
-// TODO: pake encoding/binary package
 package main
 
 import (
+	"bytes"
 	"crypto/rand"
+	"encoding/binary"
 	"errors"
 	"flag"
 	"fmt"
@@ -10,258 +11,530 @@ import (
 	"log"
 	"net"
 	"os"
+	"sync"
 
 	"golang.org/x/crypto/nacl/box"
-)
 
-// TODO: rombak semua baca smeua comment jangan ada yang salah
+	"github.com/pyk/go-challenge-2/handshake"
+	"github.com/pyk/go-challenge-2/keystore"
+)
 
 var (
 	MAX_BUFFER = 1024
 )
 
+// maxRecordSize is the largest plaintext payload carried by a single
+// framed record. Write splits larger payloads across multiple records.
+const maxRecordSize = 4096
+
 // A Box authenticates and encrypts messages using public-key cryptography.
+//
+// Box is kept as a standalone NaCl primitive for tests and callers outside
+// the wire protocol; Dial/Serve authenticate and key connections via the
+// handshake package instead, so nothing in this package's live transport
+// exercises Box, Encrypt, or Decrypt.
 type Box struct {
 	PublicKey, PeersPublicKey, privateKey *[32]byte
+
+	precomputeOnce sync.Once
+	sharedKey      *[32]byte // Curve25519 shared secret, computed once
 }
 
-// NewBox returns a new Box with random public and private keys.
+// NewBox returns a new *Box with random public and private keys.
 // The peers public Key are nil by default.
-// If failed generate random keys, it returns empty Box.
-func NewBox() (bx Box, err error) {
+// If failed generate random keys, it returns nil.
+func NewBox() (bx *Box, err error) {
 	pub, priv, err := box.GenerateKey(rand.Reader)
 	if err != nil {
-		return bx, err
+		return nil, err
 	}
-	bx = NewBoxKeys(pub, nil, priv)
-	return bx, nil
+	return NewBoxKeys(pub, nil, priv), nil
 }
 
-// NewBoxKeys returns a new Box with specified public, peers public and private keys.
-func NewBoxKeys(pub, peers, priv *[32]byte) Box {
-	return Box{pub, peers, priv}
+// NewBoxKeys returns a new *Box with specified public, peers public and private keys.
+func NewBoxKeys(pub, peers, priv *[32]byte) *Box {
+	return &Box{PublicKey: pub, PeersPublicKey: peers, privateKey: priv}
+}
+
+// precompute derives the Curve25519 shared secret from PeersPublicKey and
+// privateKey exactly once, so repeated Encrypt/Decrypt calls on the same
+// Box don't each re-run the scalar multiplication. It is safe to call from
+// multiple goroutines sharing a Box.
+func (b *Box) precompute() {
+	b.precomputeOnce.Do(func() {
+		var shared [32]byte
+		box.Precompute(&shared, b.PeersPublicKey, b.privateKey)
+		b.sharedKey = &shared
+	})
 }
 
 // Encrypt encrypts message m and returns encrypted message em if and only if err == nil.
-func (b Box) Encrypt(m []byte) (em []byte, err error) {
+func (b *Box) Encrypt(m []byte) (em []byte, err error) {
 	var nonce [24]byte
 	_, err = rand.Read(nonce[:])
 	if err != nil {
 		return nil, err
 	}
-	em = box.Seal(nonce[:], m, &nonce, b.PeersPublicKey, b.privateKey)
+	b.precompute()
+	em = box.SealAfterPrecomputation(nonce[:], m, &nonce, b.sharedKey)
 	return em, nil
 }
 
 // Decrypt decrypts encrypted message em and return decrypted message m if and only if err == nil.
 // If Box perform decryption using invalid key, it returns an error.
-func (b Box) Decrypt(em []byte) (m []byte, err error) {
+func (b *Box) Decrypt(em []byte) (m []byte, err error) {
 	var nonce [24]byte
 	copy(nonce[:], em)
-	if dm, ok := box.Open(nil, em[24:], &nonce, b.PeersPublicKey, b.privateKey); ok {
+	b.precompute()
+	if dm, ok := box.OpenAfterPrecomputation(nil, em[24:], &nonce, b.sharedKey); ok {
 		return dm, nil
 	}
 	return nil, errors.New("Decrypt: cannot decrypt the message")
 }
 
 // A Reader represents a secure reader.
+//
+// Each underlying record is framed with a 2-byte big-endian length prefix
+// followed by a ChaCha20-Poly1305 ciphertext; there is no nonce on the wire
+// because cs tracks the monotonic counter implicitly, in lockstep with the
+// peer's Writer. Read does not assume that one underlying Read returns
+// exactly one record, and decrypted plaintext that does not fit in the
+// caller's buffer is held in buf and drained before the next record is
+// read.
 type Reader struct {
-	bx Box       // box performs encryption and decryption
-	rd io.Reader // underlying Reader
+	cs  *handshake.CipherState // per-direction AEAD key and nonce counter
+	rd  io.Reader              // underlying Reader
+	buf *bytes.Buffer          // leftover decrypted plaintext not yet returned
 }
 
-// NewReader returns a new Reader
-func NewReader(rd io.Reader, bx Box) Reader {
-	return Reader{bx, rd}
+// NewReader returns a new Reader that decrypts with cs.
+func NewReader(rd io.Reader, cs *handshake.CipherState) Reader {
+	return Reader{cs, rd, new(bytes.Buffer)}
 }
 
-// Read reads decrypted message into p.
-// On returns, n == len(dm) if and only if err == nil.
-// Where dm is a decrypted message.
+// Read reads decrypted message into p, reassembling it from one or more
+// length-prefixed records read from the underlying Reader. It never reads
+// a partial record: the 2-byte length header and the record that follows
+// are each read with io.ReadFull.
 func (r Reader) Read(p []byte) (n int, err error) {
-	n, err = r.rd.Read(p)
-	if err != nil {
-		return n, err
+	if r.buf.Len() > 0 {
+		return r.buf.Read(p)
+	}
+
+	var header [2]byte
+	if _, err := io.ReadFull(r.rd, header[:]); err != nil {
+		return 0, err
 	}
-	em := p[:n]
-	dm, err := r.bx.Decrypt(em)
+	size := binary.BigEndian.Uint16(header[:])
+
+	em := make([]byte, size)
+	if _, err := io.ReadFull(r.rd, em); err != nil {
+		return 0, err
+	}
+
+	dm, err := r.cs.Decrypt(em)
 	if err != nil {
-		return n, err
+		return 0, err
 	}
-	n = copy(p, dm[:])
-	return n, nil
+
+	r.buf.Write(dm)
+	return r.buf.Read(p)
 }
 
-// A Writer represents a secure writer
+// A Writer represents a secure writer.
+//
+// Write splits p into records of at most maxRecordSize plaintext bytes and
+// frames each encrypted record with a 2-byte big-endian length header, so
+// that a single call can carry messages larger than MAX_BUFFER and the
+// peer's Reader can recover record boundaries regardless of how the
+// underlying transport batches or splits the bytes on the wire.
 type Writer struct {
-	bx Box       // box performs encryption and decryption
-	wr io.Writer // underlying Writer
+	cs *handshake.CipherState // per-direction AEAD key and nonce counter
+	wr io.Writer              // underlying Writer
 }
 
-// NewWriter returns a new Writer
-func NewWriter(wr io.Writer, bx Box) Writer {
-	return Writer{bx, wr}
+// NewWriter returns a new Writer that encrypts with cs.
+func NewWriter(wr io.Writer, cs *handshake.CipherState) Writer {
+	return Writer{cs, wr}
 }
 
-// Write encrypts p and writes n bytes to underlaying data stream.
-// On returns, n == len(em) if and only if err == nil.
+// Write encrypts p, framing it as one or more length-prefixed records, and
+// writes them to the underlying data stream.
+// On returns, n == len(p) if and only if err == nil.
 func (w Writer) Write(p []byte) (n int, err error) {
-	em, err := w.bx.Encrypt(p)
-	if err != nil {
-		return n, err
-	}
-	n, err = w.wr.Write(em)
-	if err != nil {
-		return n, err
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxRecordSize {
+			chunk = chunk[:maxRecordSize]
+		}
+
+		em, err := w.cs.Encrypt(chunk)
+		if err != nil {
+			return n, err
+		}
+
+		var header [2]byte
+		binary.BigEndian.PutUint16(header[:], uint16(len(em)))
+		if _, err := w.wr.Write(header[:]); err != nil {
+			return n, err
+		}
+		if _, err := w.wr.Write(em); err != nil {
+			return n, err
+		}
+
+		n += len(chunk)
+		p = p[len(chunk):]
 	}
 	return n, nil
 }
 
-// NewSecureReader instantiates a new Secure reader
-func NewSecureReader(r io.Reader, priv, pub *[32]byte) io.Reader {
-	bx := NewBoxKeys(pub, pub, priv)
-	rd := NewReader(r, bx)
-	return rd
+// NewSecureReader instantiates a new Secure reader over a handshake-derived
+// CipherState.
+func NewSecureReader(r io.Reader, cs *handshake.CipherState) io.Reader {
+	return NewReader(r, cs)
 }
 
-// NewSecureWriter instantiates a new Secure writer
-func NewSecureWriter(w io.Writer, priv, pub *[32]byte) io.Writer {
-	bx := NewBoxKeys(pub, pub, priv)
-	wr := NewWriter(w, bx)
-	return wr
+// NewSecureWriter instantiates a new Secure writer over a handshake-derived
+// CipherState.
+func NewSecureWriter(w io.Writer, cs *handshake.CipherState) io.Writer {
+	return NewWriter(w, cs)
 }
 
-// A Client represents a secure client
-type Client struct {
-	rd Reader
-	wr Writer
-	cn net.Conn
+// maxHandshakeMessageSize bounds a single length-prefixed handshake message
+// read off the wire, whether Noise's or the insecure transport's.
+const maxHandshakeMessageSize = 1024
+
+// ErrUnexpectedServerKey is returned by noiseTransport's SecureOutbound
+// when the server authenticates with a static public key other than the
+// one the caller expected.
+var ErrUnexpectedServerKey = errors.New("dial: server static key does not match expected key")
+
+// ErrUnauthorizedClient is returned by noiseTransport's SecureInbound when
+// the transport has an AuthorizedKeys list and the client's static key is
+// not on it.
+var ErrUnauthorizedClient = errors.New("serve: client static key is not authorized")
+
+// A Conn is a secure connection produced by the Noise transport. Reads and
+// writes are framed and encrypted. The insecure transport does not produce
+// a Conn; it returns the raw net.Conn.
+type Conn struct {
+	rd        Reader
+	wr        Writer
+	cn        net.Conn
+	handshake [32]byte // handshake hash, for binding higher-level auth
 }
 
-// NewClient returns a new Client with specified Reader, Writer and underlying
-// net.Conn.
-func NewClient(rd Reader, wr Writer, cn net.Conn) Client {
-	return Client{rd, wr, cn}
+// Handshake returns the completed Noise handshake's hash, which callers can
+// use to bind higher-level authentication (e.g. a channel-binding token) to
+// this specific connection.
+func (c Conn) Handshake() [32]byte {
+	return c.handshake
 }
 
 // Read reads decrypted message into p
-func (c Client) Read(p []byte) (n int, err error) {
-	n, err = c.rd.Read(p)
-	return
+func (c Conn) Read(p []byte) (n int, err error) {
+	return c.rd.Read(p)
 }
 
-// Write encrypt p and writes n bytes to underlying connection.
-func (c Client) Write(p []byte) (n int, err error) {
-	n, err = c.wr.Write(p)
-	return
+// Write encrypts p and writes n bytes to underlying connection.
+func (c Conn) Write(p []byte) (n int, err error) {
+	return c.wr.Write(p)
 }
 
 // Close close the connection
-func (c Client) Close() error {
+func (c Conn) Close() error {
 	return c.cn.Close()
 }
 
-// A Server represents a secure server
-type Server struct {
-	rd Reader
-	wr Writer
-	cn net.Conn
+// readHandshakeMessage reads one length-prefixed handshake message: a
+// 2-byte big-endian length header followed by the message itself.
+func readHandshakeMessage(r io.Reader) ([]byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint16(header[:])
+	if int(size) > maxHandshakeMessageSize {
+		return nil, errors.New("handshake message too large")
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeHandshakeMessage writes msg prefixed with its 2-byte big-endian
+// length, mirroring readHandshakeMessage.
+func writeHandshakeMessage(w io.Writer, msg []byte) error {
+	var header [2]byte
+	binary.BigEndian.PutUint16(header[:], uint16(len(msg)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(msg)
+	return err
+}
+
+// A SecureTransport upgrades a freshly dialed or accepted net.Conn into an
+// authenticated io.ReadWriteCloser. SecureOutbound is used by the side that
+// initiated the connection, SecureInbound by the side that accepted it;
+// both perform whatever handshake the transport requires before returning.
+//
+// This is the extension point for swapping in new transports (TLS, a
+// different Noise pattern, ...) without touching Dial/Serve.
+type SecureTransport interface {
+	SecureInbound(conn net.Conn) (io.ReadWriteCloser, error)
+	SecureOutbound(conn net.Conn) (io.ReadWriteCloser, error)
+}
+
+// noiseTransport is the default SecureTransport: it authenticates and keys
+// the connection with the Noise IK handshake from the handshake package.
+//
+// Noise IK requires the initiator to already know the responder's static
+// key before it can send the first handshake message, so SecureInbound
+// advertises its static key as a cleartext preamble (the key is public
+// information, the same way an SSH server presents its host key before the
+// client has decided whether to trust it) and SecureOutbound reads that
+// preamble before proceeding. The actual trust decision belongs to the
+// caller: a fixed remoteKey, or DialKnownHost consulting a known-hosts file.
+type noiseTransport struct {
+	static         handshake.KeyPair
+	remoteKey      [32]byte                 // expected peer static key; only used by SecureOutbound
+	authorizedKeys *keystore.AuthorizedKeys // if set, SecureInbound rejects unlisted clients
+}
+
+// NewNoiseTransport returns a SecureTransport backed by the Noise IK
+// handshake. static is this side's long-term identity. remoteKey is the
+// peer's expected static key and is only consulted by SecureOutbound.
+// authorizedKeys, if non-nil, restricts SecureInbound to clients whose
+// static key it lists; pass nil to accept any client.
+func NewNoiseTransport(static handshake.KeyPair, remoteKey [32]byte, authorizedKeys *keystore.AuthorizedKeys) SecureTransport {
+	return &noiseTransport{static, remoteKey, authorizedKeys}
+}
+
+// SecureOutbound reads the server's advertised static key, verifies it
+// against remoteKey, and performs the initiator side of the Noise IK
+// handshake.
+func (t *noiseTransport) SecureOutbound(conn net.Conn) (io.ReadWriteCloser, error) {
+	serverKey, err := readServerKeyPreamble(conn)
+	if err != nil {
+		return nil, err
+	}
+	if serverKey != t.remoteKey {
+		return nil, ErrUnexpectedServerKey
+	}
+	return completeNoiseOutbound(conn, t.static, serverKey)
+}
+
+// SecureInbound advertises t.static's public key in cleartext, then
+// performs the responder side of the Noise IK handshake. If authorizedKeys
+// is set, it returns ErrUnauthorizedClient for any client whose static key
+// is not on the list.
+func (t *noiseTransport) SecureInbound(conn net.Conn) (io.ReadWriteCloser, error) {
+	if err := writeHandshakeMessage(conn, t.static.Public[:]); err != nil {
+		return nil, err
+	}
+
+	hs, err := handshake.NewResponder(t.static)
+	if err != nil {
+		return nil, err
+	}
+
+	msg1, err := readHandshakeMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := hs.ReadMessage1(msg1); err != nil {
+		return nil, err
+	}
+
+	msg2, err := hs.WriteMessage2(nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeHandshakeMessage(conn, msg2); err != nil {
+		return nil, err
+	}
+
+	res, err := hs.Split()
+	if err != nil {
+		return nil, err
+	}
+	if t.authorizedKeys != nil && !t.authorizedKeys.Allowed(res.RemoteStatic) {
+		return nil, ErrUnauthorizedClient
+	}
+
+	rd := NewReader(conn, handshake.NewCipherState(res.Recv))
+	wr := NewWriter(conn, handshake.NewCipherState(res.Send))
+	return &Conn{rd, wr, conn, res.Hash}, nil
+}
+
+// readServerKeyPreamble reads the cleartext static-key preamble a
+// noiseTransport's SecureInbound sends ahead of the Noise handshake.
+func readServerKeyPreamble(conn net.Conn) (key [32]byte, err error) {
+	msg, err := readHandshakeMessage(conn)
+	if err != nil {
+		return key, err
+	}
+	if len(msg) != 32 {
+		return key, errors.New("handshake: malformed server key preamble")
+	}
+	copy(key[:], msg)
+	return key, nil
 }
 
-// NewServer returns a new Server
-func NewServer(rd Reader, wr Writer, cn net.Conn) Server {
-	return Server{rd, wr, cn}
+// completeNoiseOutbound runs the initiator side of the Noise IK handshake
+// against a peer already known to hold serverKey, and wraps the resulting
+// transport keys in a Conn. It is shared by noiseTransport.SecureOutbound
+// (which checks serverKey against a fixed remoteKey) and DialKnownHost
+// (which checks it against a known-hosts file).
+func completeNoiseOutbound(conn net.Conn, static handshake.KeyPair, serverKey [32]byte) (io.ReadWriteCloser, error) {
+	hs, err := handshake.NewInitiator(static, serverKey)
+	if err != nil {
+		return nil, err
+	}
+
+	msg1, err := hs.WriteMessage1(nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeHandshakeMessage(conn, msg1); err != nil {
+		return nil, err
+	}
+
+	msg2, err := readHandshakeMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := hs.ReadMessage2(msg2); err != nil {
+		return nil, err
+	}
+
+	res, err := hs.Split()
+	if err != nil {
+		return nil, err
+	}
+	if res.RemoteStatic != serverKey {
+		return nil, ErrUnexpectedServerKey
+	}
+
+	rd := NewReader(conn, handshake.NewCipherState(res.Recv))
+	wr := NewWriter(conn, handshake.NewCipherState(res.Send))
+	return &Conn{rd, wr, conn, res.Hash}, nil
+}
+
+// insecureTransport is a plaintext SecureTransport, inspired by libp2p's
+// sec/insecure: each side sends its declared identity (an unauthenticated
+// public key) and then all subsequent bytes are passed through unencrypted.
+// It exists for interop testing, protocol debugging, and benchmarking —
+// it provides no confidentiality and no real authentication, since the
+// declared identity is never proven.
+type insecureTransport struct {
+	identity [32]byte
+}
+
+// NewInsecureTransport returns a SecureTransport that performs no
+// encryption, declaring identity to the peer for debugging purposes only.
+func NewInsecureTransport(identity [32]byte) SecureTransport {
+	return &insecureTransport{identity}
 }
 
-// Dial generates a private/public key pair,
-// connects to the server, perform the handshake
-// and return a reader/writer.
-func Dial(addr string) (io.ReadWriteCloser, error) {
+func (t *insecureTransport) SecureOutbound(conn net.Conn) (io.ReadWriteCloser, error) {
+	return t.exchangeIdentity(conn)
+}
+
+func (t *insecureTransport) SecureInbound(conn net.Conn) (io.ReadWriteCloser, error) {
+	return t.exchangeIdentity(conn)
+}
+
+// exchangeIdentity swaps declared identities over a length-prefixed
+// message, identical in shape to the Noise handshake's framing, and then
+// hands back the raw connection.
+func (t *insecureTransport) exchangeIdentity(conn net.Conn) (io.ReadWriteCloser, error) {
+	if err := writeHandshakeMessage(conn, t.identity[:]); err != nil {
+		return nil, err
+	}
+	peerIdentity, err := readHandshakeMessage(conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(peerIdentity) != 32 {
+		return nil, errors.New("insecure: malformed peer identity")
+	}
+	return conn, nil
+}
+
+// Dial connects to addr and upgrades the connection via t.SecureOutbound.
+func Dial(addr string, t SecureTransport) (io.ReadWriteCloser, error) {
 	conn, err := net.Dial("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
-	// fmt.Printf("EClient: dial new connection %+v\n", client)
+	return t.SecureOutbound(conn)
+}
 
-	// generate new box
-	bx, err := NewBox()
+// DialKnownHost connects to addr, reads the server's advertised static key,
+// and authenticates it against knownHosts before completing the Noise IK
+// handshake. If addr has no known-hosts entry yet, DialKnownHost fails with
+// keystore.ErrUnknownHost unless trustOnFirstUse is set, in which case the
+// server's key is recorded as trusted. An address with a recorded entry
+// that does not match always fails with keystore.ErrHostKeyMismatch.
+func DialKnownHost(addr string, static handshake.KeyPair, knownHosts *keystore.KnownHosts, trustOnFirstUse bool) (io.ReadWriteCloser, error) {
+	conn, err := net.Dial("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
 
-	// Key exchange
-	// receive key from server
-	key := make([]byte, 32)
-	n, err := conn.Read(key)
-	// fmt.Printf("EClient: read %d bytes public key from the server p = %v\n", n, p)
-	var peersKey [32]byte
-	copy(peersKey[:], key[:n])
-	bx.PeersPublicKey = &peersKey
-	// send key to the server
-	n, err = conn.Write(bx.PublicKey[:])
+	serverKey, err := readServerKeyPreamble(conn)
 	if err != nil {
 		return nil, err
 	}
-	// fmt.Printf("EClient transfer %d bytes key ti the server %v\n", n, pub[:])
+	if err := knownHosts.Verify(addr, serverKey, trustOnFirstUse); err != nil {
+		return nil, err
+	}
+
+	return completeNoiseOutbound(conn, static, serverKey)
+}
+
+// A Server accepts connections and upgrades each one via a SecureTransport.
+type Server struct {
+	transport SecureTransport
+}
 
-	rd := NewReader(conn, bx)
-	wr := NewWriter(conn, bx)
-	c := NewClient(rd, wr, conn)
-	return c, nil
+// NewServer returns a Server that upgrades accepted connections with t.
+func NewServer(t SecureTransport) Server {
+	return Server{t}
 }
 
 // Serve starts a secure echo server on the given listener.
-func Serve(l net.Listener) error {
-	// fmt.Println("Server: executed\n")
+func (s Server) Serve(l net.Listener) error {
 	for {
-		// fmt.Println("Server: wait client connected\n")
 		client, err := l.Accept()
-		// fmt.Printf("Server: client connected. client = %v\n", client)
 		if err != nil {
 			return err
 		}
-		// fmt.Printf("Server: Run handle go routine with client = %v\n\n", client)
 
-		go handle(client)
+		go s.handle(client)
 	}
-	return nil
 }
 
-func handle(client net.Conn) {
-	bx, err := NewBox()
-	if err != nil {
-		fmt.Printf("Server: %v\n", err)
-		return
-	}
-
-	// key exchange
-	// fmt.Printf("Server: generate pub %v priv %v \n", pub, priv)
-	n, err := client.Write(bx.PublicKey[:])
-	if err != nil {
-		fmt.Printf("Server: %v\n", err)
-		return
-	}
-	// fmt.Printf("Server: send %d bytes public key to client. %v\n\n", n, pub[:])
+// handle upgrades client via the Server's transport and echoes every
+// message back to it. client is always closed on return, whether or not
+// the transport upgrade succeeds, since the returned conn (if any) aliases
+// the same underlying net.Conn.
+func (s Server) handle(client net.Conn) {
+	defer client.Close()
 
-	// get client public key
-	key := make([]byte, 32)
-	n, err = client.Read(key)
+	conn, err := s.transport.SecureInbound(client)
 	if err != nil {
 		fmt.Printf("Server: %v\n", err)
 		return
 	}
-	// fmt.Printf("\nServer: read %d bytes public key from the client p = %v\n\n", n, p)
-	var peersKey [32]byte
-	copy(peersKey[:], key[:n])
-	bx.PeersPublicKey = &peersKey
 
-	rd := NewReader(client, bx)
-	wr := NewWriter(client, bx)
-	s := NewServer(rd, wr, client)
 	for {
-		// read and decrypt message
 		p := make([]byte, MAX_BUFFER)
-		n, err := s.rd.Read(p)
+		n, err := conn.Read(p)
 		if err != nil {
 			if err == io.EOF {
 				return
@@ -270,19 +543,46 @@ func handle(client net.Conn) {
 			return
 		}
 
-		// encrypt and write to underlying connection
-		n, err = s.wr.Write(p[:n])
-		if err != nil {
+		if _, err := conn.Write(p[:n]); err != nil {
 			fmt.Printf("Server: %v\n", err)
 			return
 		}
+	}
+}
 
+// echo writes message to conn and prints back whatever conn echoes.
+func echo(conn io.ReadWriteCloser, message string) {
+	defer conn.Close()
+	if _, err := conn.Write([]byte(message)); err != nil {
+		log.Fatal(err)
 	}
+	buf := make([]byte, len(message))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s\n", buf)
 }
 
 func main() {
 	port := flag.Int("l", 0, "Listen mode. Specify port")
+	insecure := flag.Bool("insecure", false, "Use the plaintext insecure transport instead of Noise (debugging only: no encryption or real authentication)")
+	identityPath := flag.String("identity", "", "Path to a persistent Curve25519 identity key file (default: generate a new ephemeral identity)")
+	knownHostsPath := flag.String("known-hosts", "", "Path to a known-hosts file used to authenticate servers in client mode")
+	trustOnFirstUse := flag.Bool("trust-on-first-use", false, "Trust and record a server's static key the first time it's seen, instead of failing on an unknown host")
+	authorizedKeysPath := flag.String("authorized-keys", "", "Path to a file of client static keys the server accepts (default: accept any client)")
 	flag.Parse()
+	args := flag.Args()
+
+	var static handshake.KeyPair
+	var err error
+	if *identityPath != "" {
+		static, err = keystore.NewKeyStore(*identityPath).LoadOrGenerate()
+	} else {
+		static, err = handshake.GenerateKeyPair()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Server mode
 	if *port != 0 {
@@ -291,24 +591,50 @@ func main() {
 			log.Fatal(err)
 		}
 		defer l.Close()
-		log.Fatal(Serve(l))
+
+		var transport SecureTransport
+		if *insecure {
+			transport = NewInsecureTransport(static.Public)
+		} else {
+			var authorizedKeys *keystore.AuthorizedKeys
+			if *authorizedKeysPath != "" {
+				authorizedKeys, err = keystore.LoadAuthorizedKeys(*authorizedKeysPath)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+			fmt.Printf("listening with static public key %x\n", static.Public)
+			transport = NewNoiseTransport(static, [32]byte{}, authorizedKeys)
+		}
+		log.Fatal(NewServer(transport).Serve(l))
 	}
 
 	// Client mode
-	if len(os.Args) != 3 {
-		log.Fatalf("Usage: %s <port> <message>", os.Args[0])
+	if *insecure {
+		if len(args) != 2 {
+			log.Fatalf("Usage: %s -insecure <port> <message>", os.Args[0])
+		}
+		conn, err := Dial("localhost:"+args[0], NewInsecureTransport(static.Public))
+		if err != nil {
+			log.Fatal(err)
+		}
+		echo(conn, args[1])
+		return
 	}
-	conn, err := Dial("localhost:" + os.Args[1])
-	if err != nil {
-		log.Fatal(err)
+
+	if len(args) != 2 {
+		log.Fatalf("Usage: %s -known-hosts <path> <port> <message>", os.Args[0])
 	}
-	if _, err := conn.Write([]byte(os.Args[2])); err != nil {
+	if *knownHostsPath == "" {
+		log.Fatalf("client mode requires -known-hosts")
+	}
+	knownHosts, err := keystore.LoadKnownHosts(*knownHostsPath)
+	if err != nil {
 		log.Fatal(err)
 	}
-	buf := make([]byte, len(os.Args[2]))
-	n, err := conn.Read(buf)
+	conn, err := DialKnownHost("localhost:"+args[0], static, knownHosts, *trustOnFirstUse)
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("%s\n", buf[:n])
+	echo(conn, args[1])
 }
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pyk/go-challenge-2/handshake"
+	"github.com/pyk/go-challenge-2/keystore"
+)
+
+// newTestCipherStatePair returns the send and receive CipherStates for one
+// direction of a channel, as NewSecureReader/NewSecureWriter would each get
+// from opposite ends of a completed handshake's Split.
+func newTestCipherStatePair(t *testing.T) (send, recv *handshake.CipherState) {
+	t.Helper()
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		t.Fatalf("rand.Read: %v", err)
+	}
+	return handshake.NewCipherState(key), handshake.NewCipherState(key)
+}
+
+// TestReadWriter2Read writes a message across two Fprintf calls and reads it
+// back through NewSecureReader/NewSecureWriter piece by piece: the reader
+// must reassemble the framed records regardless of how the writes were
+// batched on the wire.
+func TestReadWriter2Read(t *testing.T) {
+	r, w := io.Pipe()
+
+	send, recv := newTestCipherStatePair(t)
+	sr := NewSecureReader(r, recv)
+	sw := NewSecureWriter(w, send)
+
+	go func() {
+		fmt.Fprintf(sw, "hello")
+		fmt.Fprintf(sw, "world")
+	}()
+
+	got := make([]byte, 10)
+	if _, err := io.ReadFull(sr, got); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if string(got) != "helloworld" {
+		t.Errorf("got %q, want %q", got, "helloworld")
+	}
+}
+
+// TestReadWriterOversizedMessage ensures a plaintext larger than
+// maxRecordSize is split into multiple framed records by Write and
+// transparently reassembled by Read.
+func TestReadWriterOversizedMessage(t *testing.T) {
+	r, w := io.Pipe()
+
+	send, recv := newTestCipherStatePair(t)
+	sr := NewSecureReader(r, recv)
+	sw := NewSecureWriter(w, send)
+
+	msg := make([]byte, maxRecordSize*2+17)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+
+	go func() {
+		if _, err := sw.Write(msg); err != nil {
+			t.Errorf("Write: %v", err)
+		}
+	}()
+
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(sr, got); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	for i := range msg {
+		if got[i] != msg[i] {
+			t.Fatalf("byte %d: got %d, want %d", i, got[i], msg[i])
+		}
+	}
+}
+
+// TestDialServeEcho exercises the full Noise IK handshake end to end: a
+// Server authenticates a Dial-ing client and echoes back whatever it sent.
+func TestDialServeEcho(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	serverStatic, err := handshake.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+	clientStatic, err := handshake.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+
+	srv := NewServer(NewNoiseTransport(serverStatic, [32]byte{}, nil))
+	go srv.Serve(l)
+
+	conn, err := Dial(l.Addr().String(), NewNoiseTransport(clientStatic, serverStatic.Public, nil))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	want := "hello, echo server"
+	if _, err := conn.Write([]byte(want)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestDialWrongServerKeyFails ensures Dial refuses to authenticate a server
+// presenting a different static key than the one the caller expected.
+func TestDialWrongServerKeyFails(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	serverStatic, err := handshake.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+	clientStatic, err := handshake.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+	wrongPub, err := handshake.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+
+	srv := NewServer(NewNoiseTransport(serverStatic, [32]byte{}, nil))
+	go srv.Serve(l)
+
+	if _, err := Dial(l.Addr().String(), NewNoiseTransport(clientStatic, wrongPub.Public, nil)); err == nil {
+		t.Fatal("Dial succeeded against an unexpected server key, want error")
+	}
+}
+
+// TestInsecureTransportEcho exercises Dial/Serve with the plaintext
+// insecure transport selected, confirming it can still carry an echo round
+// trip (with no encryption).
+func TestInsecureTransportEcho(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	serverID, err := handshake.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+	clientID, err := handshake.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+
+	srv := NewServer(NewInsecureTransport(serverID.Public))
+	go srv.Serve(l)
+
+	conn, err := Dial(l.Addr().String(), NewInsecureTransport(clientID.Public))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	want := "hello, plaintext"
+	if _, err := conn.Write([]byte(want)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("ReadFull: %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// TestBoxEncryptDecrypt round-trips a message through two Boxes that each
+// hold the other's public key, and exercises repeated Encrypt/Decrypt calls
+// to ensure the lazily precomputed shared key is reused correctly. Box is
+// not used by Dial/Serve (see the Box doc comment); this test only covers
+// the standalone primitive.
+func TestBoxEncryptDecrypt(t *testing.T) {
+	a, err := NewBox()
+	if err != nil {
+		t.Fatalf("NewBox: %v", err)
+	}
+	b, err := NewBox()
+	if err != nil {
+		t.Fatalf("NewBox: %v", err)
+	}
+	a.PeersPublicKey = b.PublicKey
+	b.PeersPublicKey = a.PublicKey
+
+	for i := 0; i < 3; i++ {
+		want := fmt.Sprintf("message %d", i)
+		em, err := a.Encrypt([]byte(want))
+		if err != nil {
+			t.Fatalf("Encrypt: %v", err)
+		}
+		got, err := b.Decrypt(em)
+		if err != nil {
+			t.Fatalf("Decrypt: %v", err)
+		}
+		if string(got) != want {
+			t.Errorf("round %d: got %q, want %q", i, got, want)
+		}
+	}
+}
+
+// TestDialKnownHostTrustOnFirstUse exercises the TOFU path: with no prior
+// known-hosts entry and trustOnFirstUse set, DialKnownHost records the
+// server's key and succeeds, and a second dial against the now-populated
+// file succeeds without trustOnFirstUse.
+func TestDialKnownHostTrustOnFirstUse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	serverStatic, err := handshake.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+	clientStatic, err := handshake.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+
+	srv := NewServer(NewNoiseTransport(serverStatic, [32]byte{}, nil))
+	go srv.Serve(l)
+
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	knownHosts, err := keystore.LoadKnownHosts(knownHostsPath)
+	if err != nil {
+		t.Fatalf("LoadKnownHosts: %v", err)
+	}
+
+	addr := l.Addr().String()
+	if _, err := DialKnownHost(addr, clientStatic, knownHosts, false); err != keystore.ErrUnknownHost {
+		t.Fatalf("DialKnownHost without TOFU: got err %v, want ErrUnknownHost", err)
+	}
+
+	conn, err := DialKnownHost(addr, clientStatic, knownHosts, true)
+	if err != nil {
+		t.Fatalf("DialKnownHost with TOFU: %v", err)
+	}
+	conn.Close()
+
+	knownHosts2, err := keystore.LoadKnownHosts(knownHostsPath)
+	if err != nil {
+		t.Fatalf("LoadKnownHosts: %v", err)
+	}
+	conn, err = DialKnownHost(addr, clientStatic, knownHosts2, false)
+	if err != nil {
+		t.Fatalf("DialKnownHost against recorded host: %v", err)
+	}
+	conn.Close()
+}
+
+// TestDialKnownHostMismatchFails ensures DialKnownHost refuses to connect
+// when the server's advertised key doesn't match a prior known-hosts entry,
+// even with trustOnFirstUse set.
+func TestDialKnownHostMismatchFails(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	serverStatic, err := handshake.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+	clientStatic, err := handshake.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+	wrongPub, err := handshake.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+
+	srv := NewServer(NewNoiseTransport(serverStatic, [32]byte{}, nil))
+	go srv.Serve(l)
+
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	knownHosts, err := keystore.LoadKnownHosts(knownHostsPath)
+	if err != nil {
+		t.Fatalf("LoadKnownHosts: %v", err)
+	}
+
+	addr := l.Addr().String()
+	if err := knownHosts.Verify(addr, wrongPub.Public, true); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	if _, err := DialKnownHost(addr, clientStatic, knownHosts, true); err != keystore.ErrHostKeyMismatch {
+		t.Fatalf("DialKnownHost against mismatched host: got err %v, want ErrHostKeyMismatch", err)
+	}
+}
+
+// TestNoiseTransportAuthorizedKeysRejectsUnlistedClient ensures a
+// noiseTransport configured with AuthorizedKeys refuses clients whose
+// static key is not on the list, while still accepting a listed one.
+func TestNoiseTransportAuthorizedKeysRejectsUnlistedClient(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	serverStatic, err := handshake.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+	allowedClient, err := handshake.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+	otherClient, err := handshake.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair: %v", err)
+	}
+
+	authorizedKeysPath := filepath.Join(t.TempDir(), "authorized_keys")
+	contents := base64.StdEncoding.EncodeToString(allowedClient.Public[:]) + "\n"
+	if err := os.WriteFile(authorizedKeysPath, []byte(contents), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	authorizedKeys, err := keystore.LoadAuthorizedKeys(authorizedKeysPath)
+	if err != nil {
+		t.Fatalf("LoadAuthorizedKeys: %v", err)
+	}
+
+	srv := NewServer(NewNoiseTransport(serverStatic, [32]byte{}, authorizedKeys))
+	go srv.Serve(l)
+
+	addr := l.Addr().String()
+
+	conn, err := Dial(addr, NewNoiseTransport(allowedClient, serverStatic.Public, nil))
+	if err != nil {
+		t.Fatalf("Dial with authorized client: %v", err)
+	}
+	if _, err := conn.Write([]byte("x")); err != nil {
+		t.Fatalf("Write with authorized client: %v", err)
+	}
+	echoed := make([]byte, 1)
+	if _, err := io.ReadFull(conn, echoed); err != nil {
+		t.Fatalf("authorized client's connection was not echoed to: %v", err)
+	}
+	conn.Close()
+
+	conn, err = Dial(addr, NewNoiseTransport(otherClient, serverStatic.Public, nil))
+	if err != nil {
+		t.Fatalf("Dial with unauthorized client: %v", err)
+	}
+	defer conn.Close()
+
+	p := make([]byte, 1)
+	if _, err := io.ReadFull(conn, p); err == nil {
+		t.Fatal("unauthorized client's connection was echoed to, want it to be dropped")
+	}
+}